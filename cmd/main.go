@@ -18,16 +18,26 @@ import (
 	"time"
 
 	"github.com/avigyan/k8s-priority-queue/pkg/kubernetes"
+	"github.com/avigyan/k8s-priority-queue/pkg/queue"
+	"github.com/avigyan/k8s-priority-queue/pkg/scheduler"
 	"github.com/avigyan/k8s-priority-queue/pkg/server"
+	"github.com/avigyan/k8s-priority-queue/pkg/templates"
 	"sigs.k8s.io/yaml"
 )
 
 func main() {
 	// Parse command-line flags
 	var (
-		kubeconfigPath string
-		port           int
-		maxConcurrency int
+		kubeconfigPath    string
+		port              int
+		maxConcurrency    int
+		queueStorePath    string
+		agingQuantum      time.Duration
+		agingBoost        int
+		preemptionEnabled bool
+		preemptionMargin  int
+		quotasConfigPath  string
+		templatesEnabled  bool
 	)
 
 	// Set default kubeconfig path
@@ -41,6 +51,13 @@ func main() {
 	flag.StringVar(&kubeconfigPath, "kubeconfig", defaultKubeconfigPath, "Path to kubeconfig file")
 	flag.IntVar(&port, "port", 8080, "HTTP server port")
 	flag.IntVar(&maxConcurrency, "max-concurrency", 5, "Maximum number of concurrent job submissions")
+	flag.StringVar(&queueStorePath, "queue-store", "", "Path to a BoltDB file for persisting the job queue across restarts (disabled if empty)")
+	flag.DurationVar(&agingQuantum, "aging-quantum", queue.DefaultAgingQuantum, "How often a pending job's effective priority increases by -aging-boost while it waits")
+	flag.IntVar(&agingBoost, "aging-boost", queue.DefaultAgingBoost, "How much a pending job's effective priority increases per -aging-quantum")
+	flag.BoolVar(&preemptionEnabled, "preemption-enabled", false, "Allow a high effective-priority job to evict a preemptible lower-priority running job when concurrency is saturated")
+	flag.IntVar(&preemptionMargin, "preemption-margin", 5, "Effective-priority gap that must be exceeded before a running job is preempted")
+	flag.StringVar(&quotasConfigPath, "quotas-config", "", "Path to a YAML/JSON file of pkg/scheduler.QuotaRule enforcing per-namespace/per-label concurrency quotas (disabled if empty)")
+	flag.BoolVar(&templatesEnabled, "templates-enabled", false, "Allow jobs to be submitted by named template (PUT /templates/{name}) instead of a full jobSpec")
 	flag.Parse()
 
 	log.Printf("Using kubeconfig at: %s", kubeconfigPath)
@@ -53,9 +70,49 @@ func main() {
 		log.Fatalf("Failed to create Kubernetes client: %v", err)
 	}
 
+	// Set up the job queue, optionally backed by a persistent store so
+	// pending and in-flight jobs survive a restart.
+	var (
+		jobQueue   *queue.MaxPriorityQueue
+		queueStore queue.Store
+	)
+	if queueStorePath != "" {
+		boltStore, err := queue.NewBoltStore(queueStorePath)
+		if err != nil {
+			log.Fatalf("Failed to open queue store at %s: %v", queueStorePath, err)
+		}
+		queueStore = boltStore
+
+		jobQueue, err = queue.NewDurablePriorityQueue(queueStore, agingQuantum, agingBoost)
+		if err != nil {
+			log.Fatalf("Failed to rehydrate queue from store: %v", err)
+		}
+		log.Printf("Using durable job queue backed by %s", queueStorePath)
+	} else {
+		jobQueue = queue.NewPriorityQueue(agingQuantum, agingBoost)
+	}
+
+	// Set up quota-based fair-share scheduling, if configured.
+	var sched *scheduler.Scheduler
+	if quotasConfigPath != "" {
+		rules, err := scheduler.LoadRulesFromFile(quotasConfigPath)
+		if err != nil {
+			log.Fatalf("Failed to load quota config: %v", err)
+		}
+		sched = scheduler.New(rules)
+		log.Printf("Loaded %d quota rule(s) from %s", len(rules), quotasConfigPath)
+	}
+
+	// Set up the template registry, if enabled.
+	var templateRegistry *templates.Registry
+	if templatesEnabled {
+		templateRegistry = templates.NewRegistry()
+		log.Println("Template-based job submission enabled")
+	}
+
 	// Create and start the server
-	srv := server.NewServer(kubeClient, port, maxConcurrency)
-	
+	srv := server.NewServer(kubeClient, jobQueue, queueStore, port, maxConcurrency, preemptionEnabled, preemptionMargin, sched, templateRegistry)
+
 	// Process any additional arguments as job definition files with priorities
 	remaining := flag.Args()
 	if len(remaining) > 0 {
@@ -7,10 +7,32 @@ import (
 
 	batchv1 "k8s.io/api/batch/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+// JobEventType classifies an event observed on a watched Job, including
+// terminal states derived from job.Status.Conditions that the raw
+// watch.Event type doesn't distinguish on its own.
+type JobEventType string
+
+const (
+	JobEventAdded     JobEventType = "ADDED"
+	JobEventModified  JobEventType = "MODIFIED"
+	JobEventDeleted   JobEventType = "DELETED"
+	JobEventSucceeded JobEventType = "SUCCEEDED"
+	JobEventFailed    JobEventType = "FAILED"
+)
+
+// JobEvent is emitted on the channel returned by WatchJob.
+type JobEvent struct {
+	Type JobEventType
+	Job  *batchv1.Job
+	Err  error
+}
+
 type KubeClient struct {
 	clientset *kubernetes.Clientset
 }
@@ -46,6 +68,169 @@ func (k *KubeClient) SubmitJob(ctx context.Context, job *batchv1.Job) (*batchv1.
 	return result, nil
 }
 
+// WatchJob streams lifecycle events for a single Job identified by name,
+// scoped to namespace via a metadata.name field selector. The returned
+// channel is closed when ctx is done. If the underlying watch channel
+// closes (e.g. due to a relist from the API server), WatchJob
+// transparently re-establishes it starting from the last observed
+// resource version so no events are missed; if that resourceVersion has
+// since expired (410 Gone) or the watch itself reports a watch.Error,
+// it falls back to a fresh relist instead of looping on the stale
+// version.
+func (k *KubeClient) WatchJob(ctx context.Context, namespace, name string) (<-chan JobEvent, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	events := make(chan JobEvent)
+
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	w, resourceVersion, err := k.startJobWatch(ctx, namespace, selector, "")
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer close(events)
+
+		for {
+			select {
+			case <-ctx.Done():
+				w.Stop()
+				return
+			case event, ok := <-w.ResultChan():
+				if !ok {
+					log.Printf("Watch channel closed for job %s/%s, reconnecting from resourceVersion %s", namespace, name, resourceVersion)
+					w.Stop()
+
+					newWatch, newVersion, err := k.reconnectJobWatch(ctx, namespace, selector, resourceVersion)
+					if err != nil {
+						events <- JobEvent{Err: fmt.Errorf("failed to re-establish watch for job %s/%s: %v", namespace, name, err)}
+						return
+					}
+					w = newWatch
+					resourceVersion = newVersion
+					continue
+				}
+
+				// A watch.Error event carries a *metav1.Status rather than a
+				// Job, so it must get past this check to reach its case below.
+				job, ok := event.Object.(*batchv1.Job)
+				if !ok && event.Type != watch.Error {
+					continue
+				}
+				if ok {
+					resourceVersion = job.ResourceVersion
+				}
+
+				jobEvent := JobEvent{Job: job}
+				switch event.Type {
+				case watch.Added:
+					jobEvent.Type = JobEventAdded
+				case watch.Modified:
+					jobEvent.Type = JobEventModified
+				case watch.Deleted:
+					jobEvent.Type = JobEventDeleted
+				case watch.Error:
+					// A watch.Error commonly means the API server expired
+					// resourceVersion (410 Gone); continuing to read from
+					// this watch or reconnecting with the same
+					// resourceVersion would just loop on the same error.
+					// Relist from scratch instead.
+					log.Printf("Watch error for job %s/%s, relisting from scratch", namespace, name)
+					w.Stop()
+
+					newWatch, newVersion, err := k.startJobWatch(ctx, namespace, selector, "")
+					if err != nil {
+						events <- JobEvent{Err: fmt.Errorf("failed to relist watch for job %s/%s after watch error: %v", namespace, name, err)}
+						return
+					}
+					w = newWatch
+					resourceVersion = newVersion
+					continue
+				default:
+					continue
+				}
+
+				if terminal, terminalType := terminalJobEventType(job); terminal {
+					jobEvent.Type = terminalType
+				}
+
+				events <- jobEvent
+
+				if jobEvent.Type == JobEventSucceeded || jobEvent.Type == JobEventFailed || jobEvent.Type == JobEventDeleted {
+					w.Stop()
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// reconnectJobWatch re-establishes a watch after the current one's
+// channel closed. It first tries resuming from resourceVersion; if that
+// fails (e.g. the API server has since expired it and returns 410 Gone),
+// it falls back to a fresh watch with no ResourceVersion, which triggers
+// a relist instead of looping on a resourceVersion the API server has
+// already discarded.
+func (k *KubeClient) reconnectJobWatch(ctx context.Context, namespace, fieldSelector, resourceVersion string) (watch.Interface, string, error) {
+	w, newVersion, err := k.startJobWatch(ctx, namespace, fieldSelector, resourceVersion)
+	if err == nil {
+		return w, newVersion, nil
+	}
+
+	log.Printf("Failed to resume watch from resourceVersion %s, relisting: %v", resourceVersion, err)
+	return k.startJobWatch(ctx, namespace, fieldSelector, "")
+}
+
+func (k *KubeClient) startJobWatch(ctx context.Context, namespace, fieldSelector, resourceVersion string) (watch.Interface, string, error) {
+	w, err := k.clientset.BatchV1().Jobs(namespace).Watch(ctx, metav1.ListOptions{
+		FieldSelector:   fieldSelector,
+		ResourceVersion: resourceVersion,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to watch job: %v", err)
+	}
+	return w, resourceVersion, nil
+}
+
+// terminalJobEventType inspects a Job's status conditions and reports
+// whether it has reached a terminal state.
+func terminalJobEventType(job *batchv1.Job) (bool, JobEventType) {
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != "True" {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			return true, JobEventSucceeded
+		case batchv1.JobFailed:
+			return true, JobEventFailed
+		}
+	}
+	return false, ""
+}
+
+// DeleteJob deletes a Job in the given namespace using the supplied
+// propagation policy (e.g. metav1.DeletePropagationBackground), used by
+// the server's preemption logic to evict a lower-priority running job.
+func (k *KubeClient) DeleteJob(ctx context.Context, namespace, name string, propagationPolicy metav1.DeletionPropagation) error {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	if err := k.clientset.BatchV1().Jobs(namespace).Delete(ctx, name, metav1.DeleteOptions{
+		PropagationPolicy: &propagationPolicy,
+	}); err != nil {
+		return fmt.Errorf("failed to delete job %s in namespace %s: %v", name, namespace, err)
+	}
+
+	log.Printf("Deleted job %s in namespace %s", name, namespace)
+	return nil
+}
+
 func (k *KubeClient) ListRunningJobs(ctx context.Context, namespace string) ([]batchv1.Job, error) {
 	if namespace == "" {
 		namespace = "default"
@@ -2,7 +2,9 @@ package queue
 
 import (
 	"container/heap"
+	"log"
 	"sync"
+	"time"
 )
 
 type Job struct {
@@ -10,69 +12,358 @@ type Job struct {
 	Name     string      `json:"name"`
 	Priority int         `json:"priority"`
 	Spec     interface{} `json:"spec"`
+
+	// EnqueueTime is when the job first entered the queue. It's used to
+	// compute an effective priority that ages upward over time, so a
+	// steady stream of high-priority submissions can't starve older,
+	// lower-priority jobs indefinitely.
+	EnqueueTime time.Time `json:"enqueueTime"`
+
+	// Preemptible marks whether a running instance of this job may be
+	// evicted to make room for a higher (effective) priority job.
+	Preemptible bool `json:"preemptible"`
+
+	// SchedulerBucket is the quota bucket (see pkg/scheduler) this job's
+	// slot was reserved against while dispatched, so it can be released
+	// again once the job finishes. Empty means it's unscoped.
+	SchedulerBucket string `json:"schedulerBucket,omitempty"`
+
+	// Dispatched, K8sName, K8sNamespace and CompletedAt are populated once
+	// the job has been submitted to Kubernetes, so a store-backed queue
+	// can reattach to it after a crash instead of resubmitting it.
+	Dispatched   bool       `json:"dispatched"`
+	K8sName      string     `json:"k8sName,omitempty"`
+	K8sNamespace string     `json:"k8sNamespace,omitempty"`
+	CompletedAt  *time.Time `json:"completedAt,omitempty"`
+
+	// Attempts, NotBefore and LastError track retry state for a job whose
+	// submission or execution has failed. NotBefore is set by Defer while
+	// the job sits out its exponential backoff; it's zero for a job that
+	// has never failed.
+	Attempts  int       `json:"attempts,omitempty"`
+	NotBefore time.Time `json:"notBefore,omitempty"`
+	LastError string    `json:"lastError,omitempty"`
+
+	// DeadLetter marks a job that exhausted its RetryPolicy (see
+	// MarkDeadLetter). Like Dispatched, it's excluded from queue replay
+	// on restart; unlike a completed job, it's kept out of Compact's
+	// retention sweep and reloaded via Store.LoadDeadLetter so a restart
+	// doesn't silently drop it from the dead-letter queue.
+	DeadLetter bool `json:"deadLetter,omitempty"`
 }
 
-type MaxPriorityQueue struct {
+// Default aging parameters used when a zero quantum is passed to
+// NewPriorityQueue/NewDurablePriorityQueue.
+const (
+	DefaultAgingQuantum = 30 * time.Second
+	DefaultAgingBoost   = 1
+)
+
+// jobHeap is the plain container/heap.Interface implementation backing
+// MaxPriorityQueue. It holds no lock of its own: every call into it (via
+// heap.Push/heap.Pop/heap.Init) must happen while the owning
+// MaxPriorityQueue's mu is held, since heap's up/down siftoi call Less and
+// Swap directly against items with no synchronization of their own.
+type jobHeap struct {
 	items []*Job
+
+	agingQuantum time.Duration
+	agingBoost   int
+}
+
+func (h *jobHeap) Len() int { return len(h.items) }
+
+func (h *jobHeap) Less(i, j int) bool {
+	return h.effectivePriority(h.items[i]) > h.effectivePriority(h.items[j])
+}
+
+func (h *jobHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+}
+
+func (h *jobHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(*Job))
+}
+
+func (h *jobHeap) Pop() interface{} {
+	old := h.items
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	h.items = old[0 : n-1]
+	return item
+}
+
+// effectivePriority is job.Priority plus one agingBoost for every
+// agingQuantum the job has spent waiting in the queue.
+func (h *jobHeap) effectivePriority(job *Job) int {
+	if h.agingQuantum <= 0 || job.EnqueueTime.IsZero() {
+		return job.Priority
+	}
+	age := time.Since(job.EnqueueTime)
+	steps := int(age / h.agingQuantum)
+	return job.Priority + steps*h.agingBoost
+}
+
+// MaxPriorityQueue is a thread-safe max-heap of Jobs ordered by effective
+// priority (see jobHeap.effectivePriority). Every heap.Interface call
+// against the embedded heap happens under mu via PushJob/PopJob (or the
+// constructors, before the queue is shared); callers must never reach for
+// container/heap directly against a MaxPriorityQueue.
+//
+// Because effective priority ages with wall-clock time, the heap
+// invariant goes stale between pushes: an old, aged-up job can end up
+// sitting in a leaf while a newer, lower-effective job stays at the
+// root. PopJob/Peek re-run heap.Init against current effective
+// priorities before reading the root, rather than trusting whatever
+// order the last Push/Pop left the heap in.
+type MaxPriorityQueue struct {
 	mu    sync.RWMutex
+	heap  jobHeap
+	store Store
+
+	// delayed holds jobs waiting out a retry backoff (see Defer). They're
+	// invisible to Len/Pop/PendingJobs until ReleaseReady moves them back
+	// onto the heap, so a backed-off job doesn't get redispatched early.
+	delayed []*Job
 }
 
 func (pq *MaxPriorityQueue) Len() int {
 	pq.mu.RLock()
 	defer pq.mu.RUnlock()
-	return len(pq.items)
+	return len(pq.heap.items)
 }
 
-func (pq *MaxPriorityQueue) Less(i, j int) bool {
-	return pq.items[i].Priority > pq.items[j].Priority
-}
-
-func (pq *MaxPriorityQueue) Swap(i, j int) {
-	pq.items[i], pq.items[j] = pq.items[j], pq.items[i]
+// EffectivePriority is job.Priority plus one agingBoost for every
+// agingQuantum the job has spent waiting in the queue.
+func (pq *MaxPriorityQueue) EffectivePriority(job *Job) int {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	return pq.heap.effectivePriority(job)
 }
 
-func (pq *MaxPriorityQueue) Push(x interface{}) {
+// PushJob adds job to the heap and, if the queue is store-backed,
+// persists it. The whole heap.Push call (including the Less/Swap
+// comparisons it makes while sifting job into place) runs under mu, so it
+// can't race a concurrent PopJob/PushJob from another goroutine.
+func (pq *MaxPriorityQueue) PushJob(job *Job) {
 	pq.mu.Lock()
-	defer pq.mu.Unlock()
-	item := x.(*Job)
-	pq.items = append(pq.items, item)
+	heap.Push(&pq.heap, job)
+	store := pq.store
+	pq.mu.Unlock()
+
+	if store != nil {
+		if err := store.Save(job); err != nil {
+			log.Printf("Failed to persist job %s to store: %v", job.ID, err)
+		}
+	}
 }
 
-func (pq *MaxPriorityQueue) Pop() interface{} {
+// PopJob removes and returns the highest effective-priority job, or nil
+// if the heap is empty. Like PushJob, the whole operation runs under mu.
+// It re-runs heap.Init first: effective priority ages with time, so the
+// heap order established by earlier Pushes/Pops can be stale by the time
+// this call happens, and popping against a stale order can return the
+// wrong job (see the MaxPriorityQueue doc comment).
+func (pq *MaxPriorityQueue) PopJob() *Job {
 	pq.mu.Lock()
 	defer pq.mu.Unlock()
-	old := pq.items
-	n := len(old)
-	if n == 0 {
+
+	if len(pq.heap.items) == 0 {
 		return nil
 	}
-	item := old[n-1]
-	pq.items = old[0 : n-1]
-	return item
+
+	heap.Init(&pq.heap)
+
+	// The job stays in the store past Pop: it's about to be dispatched,
+	// and Server.processJob marks it dispatched/completed so a crash
+	// mid-dispatch can be reattached to rather than resubmitted.
+	return heap.Pop(&pq.heap).(*Job)
 }
 
+// Peek returns the highest effective-priority job without removing it,
+// or nil if the heap is empty. Like PopJob, it re-runs heap.Init first
+// so it reflects current effective priorities rather than stale heap
+// order.
 func (pq *MaxPriorityQueue) Peek() *Job {
-	pq.mu.RLock()
-	defer pq.mu.RUnlock()
-	if len(pq.items) == 0 {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	if len(pq.heap.items) == 0 {
 		return nil
 	}
-	return pq.items[0]
+	heap.Init(&pq.heap)
+	return pq.heap.items[0]
 }
 
 func (pq *MaxPriorityQueue) PendingJobs() []*Job {
 	pq.mu.RLock()
 	defer pq.mu.RUnlock()
 
-	result := make([]*Job, len(pq.items))
-	copy(result, pq.items)
+	result := make([]*Job, len(pq.heap.items))
+	copy(result, pq.heap.items)
 	return result
 }
 
-func NewPriorityQueue() *MaxPriorityQueue {
+// NewPriorityQueue builds an in-memory queue. agingQuantum/agingBoost
+// configure starvation-prevention aging (see EffectivePriority); pass
+// agingQuantum == 0 to fall back to DefaultAgingQuantum/DefaultAgingBoost,
+// or queue.NoAging() (any negative agingQuantum) for strict priority
+// ordering with no aging at all.
+func NewPriorityQueue(agingQuantum time.Duration, agingBoost int) *MaxPriorityQueue {
+	agingQuantum, agingBoost = resolveAgingParams(agingQuantum, agingBoost)
+
 	pq := &MaxPriorityQueue{
-		items: make([]*Job, 0),
+		heap: jobHeap{
+			items:        make([]*Job, 0),
+			agingQuantum: agingQuantum,
+			agingBoost:   agingBoost,
+		},
 	}
-	heap.Init(pq)
+	heap.Init(&pq.heap)
 	return pq
 }
+
+// NoAging returns aging parameters that disable aging entirely, for
+// callers of NewPriorityQueue/NewDurablePriorityQueue that want a strict
+// max-heap on Priority alone.
+func NoAging() (time.Duration, int) {
+	return -1, 0
+}
+
+// resolveAgingParams maps the zero value of agingQuantum to the package
+// defaults, a negative agingQuantum (see NoAging) to "aging disabled",
+// and otherwise passes the caller's values through unchanged.
+func resolveAgingParams(agingQuantum time.Duration, agingBoost int) (time.Duration, int) {
+	switch {
+	case agingQuantum == 0:
+		return DefaultAgingQuantum, DefaultAgingBoost
+	case agingQuantum < 0:
+		return 0, 0
+	default:
+		return agingQuantum, agingBoost
+	}
+}
+
+// NewDurablePriorityQueue builds a queue backed by store. Any jobs left
+// over from a previous run that hadn't yet been dispatched are replayed
+// onto the heap so a restart doesn't drop pending submissions.
+// Already-dispatched and dead-lettered jobs are intentionally left out
+// of the heap; the caller (Server.Start) is responsible for reattaching
+// to them via store.LoadDispatched/store.LoadDeadLetter instead of
+// re-running them through the queue.
+func NewDurablePriorityQueue(store Store, agingQuantum time.Duration, agingBoost int) (*MaxPriorityQueue, error) {
+	agingQuantum, agingBoost = resolveAgingParams(agingQuantum, agingBoost)
+
+	pq := &MaxPriorityQueue{
+		store: store,
+		heap: jobHeap{
+			items:        make([]*Job, 0),
+			agingQuantum: agingQuantum,
+			agingBoost:   agingBoost,
+		},
+	}
+
+	jobs, err := store.LoadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, job := range jobs {
+		if job.Dispatched || job.DeadLetter {
+			continue
+		}
+		if job.NotBefore.After(now) {
+			pq.delayed = append(pq.delayed, job)
+		} else {
+			pq.heap.items = append(pq.heap.items, job)
+		}
+	}
+
+	heap.Init(&pq.heap)
+	return pq, nil
+}
+
+// Defer pulls job out of normal dispatch and sets it to retry no earlier
+// than notBefore. A goroutine (see Server.retryLoop) is expected to call
+// ReleaseReady periodically to move it back onto the heap once notBefore
+// has passed.
+func (pq *MaxPriorityQueue) Defer(job *Job, notBefore time.Time) {
+	job.NotBefore = notBefore
+
+	pq.mu.Lock()
+	pq.delayed = append(pq.delayed, job)
+	store := pq.store
+	pq.mu.Unlock()
+
+	if store != nil {
+		if err := store.Save(job); err != nil {
+			log.Printf("Failed to persist deferred job %s to store: %v", job.ID, err)
+		}
+	}
+}
+
+// ReleaseReady moves every deferred job whose NotBefore has passed back
+// onto the heap, and returns how many it released.
+func (pq *MaxPriorityQueue) ReleaseReady() int {
+	now := time.Now()
+
+	pq.mu.Lock()
+	var ready, stillWaiting []*Job
+	for _, job := range pq.delayed {
+		if job.NotBefore.After(now) {
+			stillWaiting = append(stillWaiting, job)
+		} else {
+			ready = append(ready, job)
+		}
+	}
+	pq.delayed = stillWaiting
+	for _, job := range ready {
+		heap.Push(&pq.heap, job)
+	}
+	pq.mu.Unlock()
+
+	return len(ready)
+}
+
+// MarkDispatched records in the backing store that job has been
+// submitted to Kubernetes, so a crash after this point reattaches to it
+// on recovery rather than resubmitting a duplicate.
+func (pq *MaxPriorityQueue) MarkDispatched(job *Job, k8sName, k8sNamespace string) {
+	job.Dispatched = true
+	job.K8sName = k8sName
+	job.K8sNamespace = k8sNamespace
+
+	if pq.store == nil {
+		return
+	}
+	if err := pq.store.MarkDispatched(job.ID, k8sName, k8sNamespace); err != nil {
+		log.Printf("Failed to mark job %s dispatched in store: %v", job.ID, err)
+	}
+}
+
+// MarkCompleted records in the backing store that job reached a
+// terminal state, making it eligible for compaction.
+func (pq *MaxPriorityQueue) MarkCompleted(job *Job) {
+	if pq.store == nil {
+		return
+	}
+	if err := pq.store.MarkCompleted(job.ID); err != nil {
+		log.Printf("Failed to mark job %s completed in store: %v", job.ID, err)
+	}
+}
+
+// MarkDeadLetter records in the backing store that job has exhausted its
+// RetryPolicy, including its final Attempts/LastError, so a restart
+// reloads it via store.LoadDeadLetter instead of silently dropping it
+// from the dead-letter queue.
+func (pq *MaxPriorityQueue) MarkDeadLetter(job *Job) {
+	job.DeadLetter = true
+
+	if pq.store == nil {
+		return
+	}
+	if err := pq.store.MarkDeadLetter(job); err != nil {
+		log.Printf("Failed to mark job %s dead-lettered in store: %v", job.ID, err)
+	}
+}
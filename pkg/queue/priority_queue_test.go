@@ -0,0 +1,230 @@
+package queue
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore is a minimal in-memory Store used to exercise
+// NewDurablePriorityQueue's recovery behavior without a real BoltDB file.
+type memStore struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func newMemStore() *memStore {
+	return &memStore{jobs: make(map[string]*Job)}
+}
+
+func (m *memStore) clone(j *Job) *Job {
+	cp := *j
+	return &cp
+}
+
+func (m *memStore) Save(job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[job.ID] = m.clone(job)
+	return nil
+}
+
+func (m *memStore) MarkDispatched(id, k8sName, k8sNamespace string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil
+	}
+	job.Dispatched = true
+	job.K8sName = k8sName
+	job.K8sNamespace = k8sNamespace
+	return nil
+}
+
+func (m *memStore) MarkCompleted(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	job.CompletedAt = &now
+	return nil
+}
+
+func (m *memStore) MarkDeadLetter(job *Job) error {
+	return m.Save(job)
+}
+
+func (m *memStore) LoadDeadLetter() ([]*Job, error) {
+	all, err := m.LoadAll()
+	if err != nil {
+		return nil, err
+	}
+	deadLettered := make([]*Job, 0)
+	for _, j := range all {
+		if j.DeadLetter {
+			deadLettered = append(deadLettered, j)
+		}
+	}
+	return deadLettered, nil
+}
+
+func (m *memStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.jobs, id)
+	return nil
+}
+
+func (m *memStore) LoadAll() ([]*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, m.clone(j))
+	}
+	return jobs, nil
+}
+
+func (m *memStore) LoadDispatched() ([]*Job, error) {
+	all, err := m.LoadAll()
+	if err != nil {
+		return nil, err
+	}
+	dispatched := make([]*Job, 0)
+	for _, j := range all {
+		if j.Dispatched && j.CompletedAt == nil {
+			dispatched = append(dispatched, j)
+		}
+	}
+	return dispatched, nil
+}
+
+func (m *memStore) Compact(olderThan time.Duration) (int, error) { return 0, nil }
+func (m *memStore) Close() error                                 { return nil }
+
+// newDurableQueue is a test helper around NewDurablePriorityQueue with
+// aging disabled, since these tests only care about dispatch/recovery
+// bookkeeping, not aging order.
+func newDurableQueue(t *testing.T, store Store) *MaxPriorityQueue {
+	t.Helper()
+	agingQuantum, agingBoost := NoAging()
+	pq, err := NewDurablePriorityQueue(store, agingQuantum, agingBoost)
+	if err != nil {
+		t.Fatalf("NewDurablePriorityQueue: %v", err)
+	}
+	return pq
+}
+
+// TestNewDurablePriorityQueue_ReplaysOnlyUndispatchedJobs verifies that a
+// job already marked dispatched before a restart is left out of the
+// rehydrated heap: the caller is expected to reattach to it via
+// LoadDispatched instead, so it isn't resubmitted as a duplicate.
+func TestNewDurablePriorityQueue_ReplaysOnlyUndispatchedJobs(t *testing.T) {
+	store := newMemStore()
+
+	pending := &Job{ID: "pending", Priority: 5, EnqueueTime: time.Now()}
+	store.Save(pending)
+
+	dispatched := &Job{ID: "dispatched", Priority: 9, EnqueueTime: time.Now()}
+	store.Save(dispatched)
+	store.MarkDispatched(dispatched.ID, "k8s-job", "default")
+
+	pq := newDurableQueue(t, store)
+
+	if got := pq.Len(); got != 1 {
+		t.Fatalf("Len() = %d, want 1 (dispatched job should not be replayed)", got)
+	}
+
+	job := pq.PopJob()
+	if job == nil || job.ID != "pending" {
+		t.Fatalf("PopJob() = %+v, want the pending job", job)
+	}
+
+	dispatchedJobs, err := store.LoadDispatched()
+	if err != nil {
+		t.Fatalf("LoadDispatched: %v", err)
+	}
+	if len(dispatchedJobs) != 1 || dispatchedJobs[0].ID != "dispatched" {
+		t.Fatalf("LoadDispatched() = %+v, want just the dispatched job", dispatchedJobs)
+	}
+}
+
+// TestNewDurablePriorityQueue_CrashBeforeMarkDispatched exercises the
+// window between PopJob and MarkDispatched: if the process crashes after
+// a worker pops a job to submit it but before MarkDispatched records
+// that with the store, the job is still recorded as not-dispatched and
+// is replayed on the next restart. That's an intentional at-least-once
+// tradeoff (a resubmit is possible, a dropped job is not) that callers
+// must be aware a duplicate Kubernetes submission can occur across it.
+func TestNewDurablePriorityQueue_CrashBeforeMarkDispatched(t *testing.T) {
+	store := newMemStore()
+
+	pq := newDurableQueue(t, store)
+
+	job := &Job{ID: "mid-dispatch", Priority: 3, EnqueueTime: time.Now()}
+	pq.PushJob(job)
+
+	popped := pq.PopJob()
+	if popped == nil || popped.ID != job.ID {
+		t.Fatalf("PopJob() = %+v, want %+v", popped, job)
+	}
+	// Simulate a crash here, before the caller reaches MarkDispatched.
+
+	recovered := newDurableQueue(t, store)
+
+	if got := recovered.Len(); got != 1 {
+		t.Fatalf("Len() after crash-mid-dispatch = %d, want 1 (job should be replayed, not lost)", got)
+	}
+	requeued := recovered.PopJob()
+	if requeued == nil || requeued.ID != job.ID {
+		t.Fatalf("PopJob() after recovery = %+v, want %+v", requeued, job)
+	}
+
+	dispatchedJobs, err := store.LoadDispatched()
+	if err != nil {
+		t.Fatalf("LoadDispatched: %v", err)
+	}
+	if len(dispatchedJobs) != 0 {
+		t.Fatalf("LoadDispatched() = %+v, want none: MarkDispatched was never reached", dispatchedJobs)
+	}
+}
+
+// TestNewDurablePriorityQueue_CrashAfterMarkDispatched verifies the
+// other side of the same window: once MarkDispatched has landed, a
+// restart leaves the job out of the rehydrated heap (so it isn't
+// resubmitted) and surfaces it via LoadDispatched for reattachment
+// instead.
+func TestNewDurablePriorityQueue_CrashAfterMarkDispatched(t *testing.T) {
+	store := newMemStore()
+
+	pq := newDurableQueue(t, store)
+
+	job := &Job{ID: "dispatched-before-crash", Priority: 3, EnqueueTime: time.Now()}
+	pq.PushJob(job)
+
+	popped := pq.PopJob()
+	if popped == nil {
+		t.Fatalf("PopJob() returned nil")
+	}
+	pq.MarkDispatched(popped, "k8s-job", "default")
+	// Simulate a crash here, after MarkDispatched but before the job
+	// reaches a terminal state.
+
+	recovered := newDurableQueue(t, store)
+
+	if got := recovered.Len(); got != 0 {
+		t.Fatalf("Len() after crash-post-dispatch = %d, want 0 (job must not be resubmitted)", got)
+	}
+
+	dispatchedJobs, err := store.LoadDispatched()
+	if err != nil {
+		t.Fatalf("LoadDispatched: %v", err)
+	}
+	if len(dispatchedJobs) != 1 || dispatchedJobs[0].ID != job.ID {
+		t.Fatalf("LoadDispatched() = %+v, want just %s for reattachment", dispatchedJobs, job.ID)
+	}
+}
@@ -0,0 +1,232 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var jobsBucket = []byte("jobs")
+
+// Store persists queued jobs so that pending and in-flight work survives
+// a server restart. PushJob on MaxPriorityQueue writes through to the
+// configured Store; PopJob leaves the entry in place, and the job's later
+// progress is recorded via MarkDispatched/MarkCompleted. On startup the
+// queue is rehydrated from it.
+type Store interface {
+	// Save persists a newly enqueued job.
+	Save(job *Job) error
+	// MarkDispatched records that a job has been submitted to Kubernetes
+	// as k8sName/k8sNamespace, so a crash after submission doesn't cause
+	// it to be resubmitted on recovery.
+	MarkDispatched(id, k8sName, k8sNamespace string) error
+	// MarkCompleted records that a dispatched job reached a terminal
+	// state, making it eligible for compaction.
+	MarkCompleted(id string) error
+	// MarkDeadLetter persists job (including its final Attempts/
+	// LastError) as dead-lettered: a terminal state distinct from
+	// MarkCompleted, excluded from queue replay on restart like a
+	// completed job but kept out of Compact's retention sweep and
+	// reloadable via LoadDeadLetter, so a restart doesn't silently drop
+	// it from the dead-letter queue.
+	MarkDeadLetter(job *Job) error
+	// Delete removes a job from the store entirely.
+	Delete(id string) error
+	// LoadAll returns every job currently in the store.
+	LoadAll() ([]*Job, error)
+	// LoadDispatched returns jobs that were marked dispatched but not
+	// yet completed, so the caller can reattach watches instead of
+	// resubmitting them.
+	LoadDispatched() ([]*Job, error)
+	// LoadDeadLetter returns jobs that were marked dead-lettered, so the
+	// caller can rehydrate the in-memory dead-letter queue after a
+	// restart.
+	LoadDeadLetter() ([]*Job, error)
+	// Compact deletes completed entries older than olderThan and reports
+	// how many were removed.
+	Compact(olderThan time.Duration) (int, error)
+	Close() error
+}
+
+// BoltStore is a Store backed by a local BoltDB file.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (creating if necessary) a BoltDB-backed Store at path.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt store at %s: %v", path, err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize bolt store: %v", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (b *BoltStore) Save(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job %s: %v", job.ID, err)
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(job.ID), data)
+	})
+}
+
+func (b *BoltStore) MarkDispatched(id, k8sName, k8sNamespace string) error {
+	return b.updateJob(id, func(job *Job) {
+		job.Dispatched = true
+		job.K8sName = k8sName
+		job.K8sNamespace = k8sNamespace
+	})
+}
+
+func (b *BoltStore) MarkCompleted(id string) error {
+	return b.updateJob(id, func(job *Job) {
+		now := time.Now()
+		job.CompletedAt = &now
+	})
+}
+
+// MarkDeadLetter re-persists job's full current state (its caller is
+// expected to have already set job.DeadLetter and its final
+// Attempts/LastError) rather than mutating a single field by ID like
+// MarkDispatched/MarkCompleted do, since dead-lettering needs to carry
+// over more than one field at once.
+func (b *BoltStore) MarkDeadLetter(job *Job) error {
+	return b.Save(job)
+}
+
+func (b *BoltStore) updateJob(id string, mutate func(job *Job)) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("job %s not found in store", id)
+		}
+
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			return fmt.Errorf("failed to unmarshal job %s: %v", id, err)
+		}
+
+		mutate(&job)
+
+		updated, err := json.Marshal(&job)
+		if err != nil {
+			return fmt.Errorf("failed to marshal job %s: %v", id, err)
+		}
+		return bucket.Put([]byte(id), updated)
+	})
+}
+
+func (b *BoltStore) Delete(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Delete([]byte(id))
+	})
+}
+
+func (b *BoltStore) LoadAll() ([]*Job, error) {
+	var jobs []*Job
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return fmt.Errorf("failed to unmarshal job %s: %v", k, err)
+			}
+			jobs = append(jobs, &job)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load jobs from store: %v", err)
+	}
+	return jobs, nil
+}
+
+func (b *BoltStore) LoadDispatched() ([]*Job, error) {
+	all, err := b.LoadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	dispatched := make([]*Job, 0)
+	for _, job := range all {
+		if job.Dispatched && job.CompletedAt == nil {
+			dispatched = append(dispatched, job)
+		}
+	}
+	return dispatched, nil
+}
+
+func (b *BoltStore) LoadDeadLetter() ([]*Job, error) {
+	all, err := b.LoadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	deadLettered := make([]*Job, 0)
+	for _, job := range all {
+		if job.DeadLetter {
+			deadLettered = append(deadLettered, job)
+		}
+	}
+	return deadLettered, nil
+}
+
+func (b *BoltStore) Compact(olderThan time.Duration) (int, error) {
+	cutoff := time.Now().Add(-olderThan)
+	removed := 0
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+
+		// bbolt documents bucket.Delete as unsafe to call from inside a
+		// ForEach over the same bucket: deleting a key shifts the
+		// in-node indices the iterating cursor is walking and can cause
+		// the key right after it to be skipped. Collect the keys to
+		// delete during the read pass, then delete them once ForEach has
+		// returned.
+		var stale [][]byte
+		if err := bucket.ForEach(func(k, v []byte) error {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				// Skip malformed entries rather than aborting compaction.
+				return nil
+			}
+			if job.CompletedAt != nil && job.CompletedAt.Before(cutoff) {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, k := range stale {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("failed to compact store: %v", err)
+	}
+	return removed, nil
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
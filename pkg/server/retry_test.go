@@ -0,0 +1,68 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Retries(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy RetryPolicy
+		kind   string
+		want   bool
+	}{
+		{"empty RetryOn retries everything", RetryPolicy{}, FailureKindSubmission, true},
+		{"matching kind retries", RetryPolicy{RetryOn: []string{FailureKindExecution}}, FailureKindExecution, true},
+		{"non-matching kind does not retry", RetryPolicy{RetryOn: []string{FailureKindExecution}}, FailureKindSubmission, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.policy.retries(tc.kind); got != tc.want {
+				t.Errorf("retries(%q) = %v, want %v", tc.kind, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_BackoffFor(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     8 * time.Second,
+		Multiplier:     2,
+	}
+
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 8 * time.Second}, // capped at MaxBackoff
+	}
+
+	for _, tc := range cases {
+		if got := policy.backoffFor(tc.attempt); got != tc.want {
+			t.Errorf("backoffFor(%d) = %s, want %s", tc.attempt, got, tc.want)
+		}
+	}
+}
+
+func TestRetryPolicy_BackoffFor_Defaults(t *testing.T) {
+	// A zero-value policy should fall back to sane defaults rather than
+	// looping forever or returning a zero backoff.
+	var policy RetryPolicy
+
+	got := policy.backoffFor(1)
+	if got != time.Second {
+		t.Errorf("backoffFor(1) with defaults = %s, want %s", got, time.Second)
+	}
+
+	got = policy.backoffFor(10)
+	if got != 10*time.Second {
+		t.Errorf("backoffFor(10) with defaults = %s, want the default MaxBackoff of %s", got, 10*time.Second)
+	}
+}
@@ -4,20 +4,23 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"container/heap"
-
 	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/google/uuid"
 
 	"github.com/avigyan/k8s-priority-queue/pkg/kubernetes"
 	"github.com/avigyan/k8s-priority-queue/pkg/queue"
+	"github.com/avigyan/k8s-priority-queue/pkg/scheduler"
+	"github.com/avigyan/k8s-priority-queue/pkg/templates"
 )
 
 type JobSpec struct {
@@ -25,6 +28,103 @@ type JobSpec struct {
 	Priority  int         `json:"priority"`
 	Namespace string      `json:"namespace"`
 	JobSpec   interface{} `json:"jobSpec"`
+
+	// Template and Params are an alternative to JobSpec: if Template is
+	// set, it names a pkg/templates revision that's rendered into a
+	// batch/v1.Job (using Params to fill in its placeholders) before
+	// submission, so callers don't have to hand-craft the manifest.
+	// JobSpec is ignored when Template is set.
+	Template string                 `json:"template,omitempty"`
+	Params   map[string]interface{} `json:"params,omitempty"`
+
+	// RetryPolicy controls what happens when this job fails to submit or
+	// fails once running. Nil means no retries: the first failure moves
+	// the job straight to the dead-letter queue.
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
+
+	// Preemptible opts this job in (or out) of being evicted by a
+	// higher effective-priority job when preemption is enabled on the
+	// server. Defaults to false: a job is only preemptible if it says so.
+	Preemptible bool `json:"preemptible"`
+
+	// Labels are matched against pkg/scheduler quota rules keyed by
+	// label (e.g. tenant/user), in addition to the namespace-keyed rules
+	// matched via Namespace.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// FailureKindSubmission and FailureKindExecution classify why a job
+// failed, so RetryPolicy.RetryOn can
+// retry submission failures (the Kubernetes API rejected the job) and
+// execution failures (the job ran and its pod(s) failed) differently.
+const (
+	FailureKindSubmission = "submission"
+	FailureKindExecution  = "execution"
+)
+
+// RetryPolicy governs how a failed job is retried before it's moved to
+// the dead-letter queue. Backoff for the n'th attempt is
+// min(InitialBackoff*Multiplier^(n-1), MaxBackoff).
+type RetryPolicy struct {
+	MaxAttempts    int           `json:"maxAttempts"`
+	InitialBackoff time.Duration `json:"initialBackoff"`
+	MaxBackoff     time.Duration `json:"maxBackoff"`
+	Multiplier     float64       `json:"multiplier"`
+
+	// RetryOn restricts retries to the listed failure kinds
+	// (FailureKindSubmission/FailureKindExecution). Empty means retry on
+	// either kind.
+	RetryOn []string `json:"retryOn,omitempty"`
+}
+
+// retries reports whether kind is eligible for a retry under this policy.
+func (p RetryPolicy) retries(kind string) bool {
+	if len(p.RetryOn) == 0 {
+		return true
+	}
+	for _, k := range p.RetryOn {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// backoffFor returns the delay to wait before redispatching a job on its
+// attempt'th failure (1-indexed), after filling in sane defaults for any
+// unset fields.
+func (p RetryPolicy) backoffFor(attempt int) time.Duration {
+	initial, max, multiplier := p.InitialBackoff, p.MaxBackoff, p.Multiplier
+	if initial <= 0 {
+		initial = time.Second
+	}
+	if max <= 0 {
+		max = 10 * initial
+	}
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	delay := initial
+	for i := 1; i < attempt; i++ {
+		if delay >= max {
+			return max
+		}
+		delay = time.Duration(float64(delay) * multiplier)
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// DeadLetterJobResponse is the shape returned by GET /jobs/dead-letter.
+type DeadLetterJobResponse struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Priority  int    `json:"priority"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"lastError,omitempty"`
 }
 
 type JobResponse struct {
@@ -35,14 +135,34 @@ type JobResponse struct {
 }
 
 type PendingJobResponse struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	Priority int    `json:"priority"`
+	ID                string  `json:"id"`
+	Name              string  `json:"name"`
+	Priority          int     `json:"priority"`
+	EffectivePriority int     `json:"effectivePriority"`
+	AgeSeconds        float64 `json:"ageSeconds"`
+}
+
+// JobStatus is the last-known lifecycle state of a submitted job, kept
+// up to date by the watch events consumed in processJob.
+type JobStatus struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Namespace string    `json:"namespace"`
+	Priority  int       `json:"priority"`
+	Phase     string    `json:"phase"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// jobStatusEvent is the payload broadcast to /jobs/events subscribers.
+type jobStatusEvent struct {
+	Type   string    `json:"type"`
+	Status JobStatus `json:"status"`
 }
 
 type Server struct {
 	kubeClient       *kubernetes.KubeClient
 	priorityQueue    *queue.MaxPriorityQueue
+	store            queue.Store
 	enqueueCh        chan *queue.Job
 	maxConcurrency   int
 	runningJobsMutex sync.RWMutex
@@ -50,22 +170,80 @@ type Server struct {
 	httpServer       *http.Server
 	shutdownCh       chan struct{}
 	wg               sync.WaitGroup
+
+	statusMutex sync.RWMutex
+	jobStatuses map[string]JobStatus
+
+	subscribersMutex sync.Mutex
+	subscribers      map[chan jobStatusEvent]struct{}
+
+	watchCancelMutex sync.Mutex
+	watchCancels     map[string]context.CancelFunc
+
+	// preemptionEnabled and preemptionMargin control whether a newly
+	// enqueued job may evict a lower-priority preemptible running job
+	// when concurrency is saturated. See maybePreempt.
+	preemptionEnabled bool
+	preemptionMargin  int
+
+	// scheduler enforces per-namespace/per-label concurrency quotas on
+	// top of maxConcurrency. Nil means no quotas are enforced.
+	scheduler *scheduler.Scheduler
+
+	// templates resolves JobSpec.Template/Params into a batch/v1.Job
+	// before submission. Nil means template-based submission is disabled
+	// and JobSpec.Template is rejected.
+	templates *templates.Registry
+
+	// deadLetterMutex/deadLetterJobs hold jobs that exhausted their
+	// RetryPolicy, exposed via GET/POST /jobs/dead-letter. When store is
+	// non-nil they're also persisted (see queue.Job.DeadLetter) and
+	// reloaded into this map on Start via reattachDeadLetterJobs, so a
+	// restart doesn't drop the dead-letter queue.
+	deadLetterMutex sync.RWMutex
+	deadLetterJobs  map[string]*queue.Job
 }
 
-func NewServer(kubeClient *kubernetes.KubeClient, port int, maxConcurrency int) *Server {
+// NewServer wires up the HTTP server around the given priority queue.
+// pq may be a plain in-memory queue.NewPriorityQueue() or a
+// queue.NewDurablePriorityQueue(store) that already replayed pending
+// jobs from store on construction; store may be nil if no persistence
+// is configured, in which case a restart drops in-flight work as before.
+// preemptionEnabled/preemptionMargin configure the optional preemption
+// mode described on maybePreempt; pass preemptionEnabled=false to
+// disable it entirely. sched may be nil to disable per-namespace/
+// per-label quotas and dispatch purely off maxConcurrency. templateRegistry
+// may be nil to disable template-based submission entirely.
+func NewServer(kubeClient *kubernetes.KubeClient, pq *queue.MaxPriorityQueue, store queue.Store, port int, maxConcurrency int, preemptionEnabled bool, preemptionMargin int, sched *scheduler.Scheduler, templateRegistry *templates.Registry) *Server {
 	s := &Server{
-		kubeClient:     kubeClient,
-		priorityQueue:  queue.NewPriorityQueue(),
-		enqueueCh:      make(chan *queue.Job),
-		maxConcurrency: maxConcurrency,
-		runningJobs:    make(map[string]*queue.Job),
-		shutdownCh:     make(chan struct{}),
+		kubeClient:        kubeClient,
+		priorityQueue:     pq,
+		store:             store,
+		enqueueCh:         make(chan *queue.Job),
+		maxConcurrency:    maxConcurrency,
+		runningJobs:       make(map[string]*queue.Job),
+		shutdownCh:        make(chan struct{}),
+		jobStatuses:       make(map[string]JobStatus),
+		subscribers:       make(map[chan jobStatusEvent]struct{}),
+		watchCancels:      make(map[string]context.CancelFunc),
+		preemptionEnabled: preemptionEnabled,
+		preemptionMargin:  preemptionMargin,
+		scheduler:         sched,
+		templates:         templateRegistry,
+		deadLetterJobs:    make(map[string]*queue.Job),
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/jobs", s.handleJobs)
 	mux.HandleFunc("/jobs/pending", s.handlePendingJobs)
 	mux.HandleFunc("/jobs/running", s.handleRunningJobs)
+	mux.HandleFunc("/jobs/events", s.handleJobEvents)
+	mux.HandleFunc("/jobs/dead-letter", s.handleDeadLetterJobs)
+	mux.HandleFunc("/jobs/dead-letter/", s.handleDeadLetterRequeue)
+	mux.HandleFunc("/jobs/", s.handleJobStatus)
+	mux.HandleFunc("/scheduler/state", s.handleSchedulerState)
+	mux.HandleFunc("/config/quotas", s.handleConfigQuotas)
+	mux.HandleFunc("/templates/", s.handleTemplates)
 
 	s.httpServer = &http.Server{
 		Addr:    fmt.Sprintf(":%d", port),
@@ -76,6 +254,15 @@ func NewServer(kubeClient *kubernetes.KubeClient, port int, maxConcurrency int)
 }
 
 func (s *Server) Start() error {
+	if s.store != nil {
+		if err := s.reattachDispatchedJobs(); err != nil {
+			return fmt.Errorf("failed to reattach dispatched jobs from store: %v", err)
+		}
+		if err := s.reattachDeadLetterJobs(); err != nil {
+			return fmt.Errorf("failed to reattach dead-letter jobs from store: %v", err)
+		}
+	}
+
 	for i := 0; i < s.maxConcurrency; i++ {
 		s.wg.Add(1)
 		go s.worker()
@@ -84,6 +271,14 @@ func (s *Server) Start() error {
 	s.wg.Add(1)
 	go s.queueProcessor()
 
+	s.wg.Add(1)
+	go s.retryLoop()
+
+	if s.store != nil {
+		s.wg.Add(1)
+		go s.compactionLoop()
+	}
+
 	log.Printf("Starting HTTP server on %s", s.httpServer.Addr)
 	if err := s.httpServer.ListenAndServe(); err != http.ErrServerClosed {
 		return fmt.Errorf("http server error: %v", err)
@@ -120,18 +315,39 @@ func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	job := &queue.Job{
-		ID:       uuid.New().String(),
-		Name:     jobSpec.Name,
-		Priority: jobSpec.Priority,
-		Spec:     jobSpec,
+	if jobSpec.Template != "" {
+		if s.templates == nil {
+			http.Error(w, "Template-based submission is not enabled on this server", http.StatusNotImplemented)
+			return
+		}
+		renderedJob, _, err := s.templates.Render(jobSpec.Template, jobSpec.Params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		jobSpec.JobSpec = renderedJob
+	} else if jobSpec.JobSpec == nil {
+		http.Error(w, "One of jobSpec or template is required", http.StatusBadRequest)
+		return
 	}
 
-	position := s.priorityQueue.GetPositionByPriority(job.Priority)
+	job := &queue.Job{
+		ID:          uuid.New().String(),
+		Name:        jobSpec.Name,
+		Priority:    jobSpec.Priority,
+		Spec:        jobSpec,
+		EnqueueTime: time.Now(),
+		Preemptible: jobSpec.Preemptible,
+	}
 
-	heap.Push(s.priorityQueue, job)
+	s.priorityQueue.PushJob(job)
+	position := s.priorityQueue.Len()
 	log.Printf("Job %s with priority %d added to queue at position %d", job.Name, job.Priority, position)
 
+	if s.preemptionEnabled {
+		s.maybePreempt(job)
+	}
+
 	response := JobResponse{
 		ID:            job.ID,
 		Name:          job.Name,
@@ -154,13 +370,16 @@ func (s *Server) handlePendingJobs(w http.ResponseWriter, r *http.Request) {
 	pendingJobs := s.priorityQueue.PendingJobs()
 	log.Printf("Found %d pending jobs", len(pendingJobs))
 
-	response := make([]map[string]interface{}, len(pendingJobs))
+	response := make([]PendingJobResponse, len(pendingJobs))
 	for i, job := range pendingJobs {
-		log.Printf("Pending job %d: %s (priority %d)", i+1, job.Name, job.Priority)
-		response[i] = map[string]interface{}{
-			"id":       job.ID,
-			"name":     job.Name,
-			"priority": job.Priority,
+		effPriority := s.priorityQueue.EffectivePriority(job)
+		log.Printf("Pending job %d: %s (priority %d, effective %d)", i+1, job.Name, job.Priority, effPriority)
+		response[i] = PendingJobResponse{
+			ID:                job.ID,
+			Name:              job.Name,
+			Priority:          job.Priority,
+			EffectivePriority: effPriority,
+			AgeSeconds:        time.Since(job.EnqueueTime).Seconds(),
 		}
 	}
 
@@ -210,29 +429,57 @@ func (s *Server) handleRunningJobs(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(runningJobs)
 }
 
-func (s *Server) queueProcessor() {
+const (
+	compactionInterval = 1 * time.Hour
+	completedRetention = 24 * time.Hour
+)
+
+// compactionLoop periodically removes completed job entries older than
+// completedRetention from the store, so it doesn't grow unbounded.
+func (s *Server) compactionLoop() {
 	defer s.wg.Done()
 
+	ticker := time.NewTicker(compactionInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-s.shutdownCh:
-			log.Println("Queue processor shutting down...")
 			return
-		default:
-			// Check if we can process more jobs
-			ctx := context.Background()
-			runningK8sJobs, err := s.kubeClient.ListRunningJobs(ctx, "")
+		case <-ticker.C:
+			removed, err := s.store.Compact(completedRetention)
 			if err != nil {
-				log.Printf("Error listing running jobs: %v. Will retry.", err)
-				time.Sleep(500 * time.Millisecond)
+				log.Printf("Error compacting store: %v", err)
 				continue
 			}
+			if removed > 0 {
+				log.Printf("Compacted %d completed job(s) from store", removed)
+			}
+		}
+	}
+}
 
-			// Only process jobs if below max concurrency
-			if len(runningK8sJobs) >= s.maxConcurrency {
-				log.Printf("Max concurrency (%d) reached with %d running jobs. Waiting...",
-					s.maxConcurrency, len(runningK8sJobs))
-				time.Sleep(2 * time.Second)
+// runningCount returns the number of jobs we currently believe are
+// running, based on the in-memory map kept up to date by watch events.
+// This replaces the old pattern of polling ListRunningJobs on every
+// loop iteration, which scaled poorly with queue depth.
+func (s *Server) runningCount() int {
+	s.runningJobsMutex.RLock()
+	defer s.runningJobsMutex.RUnlock()
+	return len(s.runningJobs)
+}
+
+func (s *Server) queueProcessor() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.shutdownCh:
+			log.Println("Queue processor shutting down...")
+			return
+		default:
+			if s.runningCount() >= s.maxConcurrency {
+				time.Sleep(500 * time.Millisecond)
 				continue
 			}
 
@@ -242,14 +489,12 @@ func (s *Server) queueProcessor() {
 				continue
 			}
 
-			// Get highest priority job
-			job := heap.Pop(s.priorityQueue)
-			if job == nil {
+			jobData := s.nextDispatchableJob()
+			if jobData == nil {
 				time.Sleep(100 * time.Millisecond)
 				continue
 			}
 
-			jobData := job.(*queue.Job)
 			log.Printf("Queue processor dispatching job %s with priority %d", jobData.Name, jobData.Priority)
 
 			s.runningJobsMutex.Lock()
@@ -272,35 +517,22 @@ func (s *Server) worker() {
 			log.Println("Worker shutting down...")
 			return
 		default:
-			// Check current concurrency against Kubernetes, not just in-memory map
-			ctx := context.Background()
-			runningK8sJobs, err := s.kubeClient.ListRunningJobs(ctx, "")
-			if err != nil {
-				log.Printf("Error listing running jobs: %v. Will retry.", err)
+			if s.runningCount() >= s.maxConcurrency {
 				time.Sleep(500 * time.Millisecond)
 				continue
 			}
 
-			if len(runningK8sJobs) >= s.maxConcurrency {
-				log.Printf("Max concurrency (%d) reached with %d running jobs. Waiting...",
-					s.maxConcurrency, len(runningK8sJobs))
-				time.Sleep(2 * time.Second)
-				continue
-			}
-
 			if s.priorityQueue.Len() == 0 {
 				time.Sleep(100 * time.Millisecond)
 				continue
 			}
 
-			job := heap.Pop(s.priorityQueue)
-
-			if job == nil {
+			jobData := s.nextDispatchableJob()
+			if jobData == nil {
 				time.Sleep(100 * time.Millisecond)
 				continue
 			}
 
-			jobData := job.(*queue.Job)
 			log.Printf("Worker processing job %s with priority %d", jobData.Name, jobData.Priority)
 
 			s.runningJobsMutex.Lock()
@@ -320,11 +552,13 @@ func (s *Server) processJob(j *queue.Job) {
 	jobSpecBytes, err := json.Marshal(jobSpec.JobSpec)
 	if err != nil {
 		log.Printf("Error marshaling job spec: %v", err)
+		s.removeRunningJob(j)
 		return
 	}
 
 	if err := json.Unmarshal(jobSpecBytes, &k8sJob); err != nil {
 		log.Printf("Error unmarshaling job spec to batch/v1.Job: %v", err)
+		s.removeRunningJob(j)
 		return
 	}
 
@@ -338,38 +572,697 @@ func (s *Server) processJob(j *queue.Job) {
 	result, err := s.kubeClient.SubmitJob(ctx, k8sJob)
 	if err != nil {
 		log.Printf("Error submitting job %s: %v", j.Name, err)
+		s.removeRunningJob(j)
+		s.handleJobFailure(j, FailureKindSubmission, err)
 		return
 	}
 
 	log.Printf("Job %s submitted successfully. Kubernetes job name: %s", j.Name, result.Name)
 
-	log.Printf("Job %s submitted, will monitor for completion", j.Name)
+	s.priorityQueue.MarkDispatched(j, result.Name, result.Namespace)
+	s.setJobStatus(j, result.Namespace, "Submitted")
 
-	for {
-		time.Sleep(5 * time.Second)
+	s.watchDispatchedJob(j, result.Name, result.Namespace)
+}
 
-		jobs, err := s.kubeClient.ListRunningJobs(ctx, k8sJob.Namespace)
-		if err != nil {
-			log.Printf("Error checking job %s status: %v", j.Name, err)
+// watchDispatchedJob attaches a watch to an already-submitted Kubernetes
+// job and tracks it through to a terminal state. It's shared by
+// processJob (freshly dispatched jobs) and reattachDispatchedJobs
+// (jobs recovered from the store after a crash). The watch's context is
+// tracked per-job so maybePreempt can cancel it out from under an evicted
+// job before deleting it in Kubernetes.
+func (s *Server) watchDispatchedJob(j *queue.Job, k8sName, k8sNamespace string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.watchCancelMutex.Lock()
+	s.watchCancels[j.ID] = cancel
+	s.watchCancelMutex.Unlock()
+	defer func() {
+		s.watchCancelMutex.Lock()
+		delete(s.watchCancels, j.ID)
+		s.watchCancelMutex.Unlock()
+		cancel()
+	}()
+
+	events, err := s.kubeClient.WatchJob(ctx, k8sNamespace, k8sName)
+	if err != nil {
+		log.Printf("Error starting watch for job %s: %v", j.Name, err)
+		s.removeRunningJob(j)
+		return
+	}
+
+	log.Printf("Job %s submitted, watching for lifecycle events", j.Name)
+
+	for event := range events {
+		if event.Err != nil {
+			log.Printf("Watch error for job %s: %v", j.Name, event.Err)
 			continue
 		}
 
-		jobStillRunning := false
-		for _, runningJob := range jobs {
-			if runningJob.Name == result.Name && runningJob.Namespace == result.Namespace {
-				jobStillRunning = true
-				break
+		phase := string(event.Type)
+		s.setJobStatus(j, k8sNamespace, phase)
+
+		switch event.Type {
+		case kubernetes.JobEventSucceeded, kubernetes.JobEventDeleted:
+			log.Printf("Job %s reached terminal state %s", j.Name, phase)
+			s.priorityQueue.MarkCompleted(j)
+			s.removeRunningJob(j)
+			return
+		case kubernetes.JobEventFailed:
+			log.Printf("Job %s reached terminal state %s", j.Name, phase)
+			s.removeRunningJob(j)
+			s.handleJobFailure(j, FailureKindExecution, executionFailureReason(event.Job))
+			return
+		}
+	}
+
+	// Watch channel closed without a terminal event (e.g. context canceled
+	// during shutdown); stop tracking the job either way.
+	s.removeRunningJob(j)
+}
+
+// executionFailureReason extracts a human-readable cause from a Job's
+// Failed status condition, for use as the LastError recorded against a
+// job that reached Kubernetes but failed once running.
+func executionFailureReason(k8sJob *batchv1.Job) error {
+	if k8sJob != nil {
+		for _, cond := range k8sJob.Status.Conditions {
+			if cond.Type == batchv1.JobFailed && cond.Status == "True" {
+				return fmt.Errorf("%s: %s", cond.Reason, cond.Message)
 			}
 		}
+	}
+	return fmt.Errorf("job reported failed status")
+}
 
-		if !jobStillRunning {
-			log.Printf("Job %s completed or failed in Kubernetes", j.Name)
-			s.runningJobsMutex.Lock()
-			delete(s.runningJobs, j.ID)
-			s.runningJobsMutex.Unlock()
+// handleJobFailure records a submission or execution failure against j
+// and either defers it for another attempt under its JobSpec.RetryPolicy
+// or, once that policy is exhausted (or absent), moves it to the
+// dead-letter queue.
+func (s *Server) handleJobFailure(j *queue.Job, kind string, cause error) {
+	j.Attempts++
+	if cause != nil {
+		j.LastError = cause.Error()
+	}
+
+	jobSpec, _ := j.Spec.(JobSpec)
+	policy := jobSpec.RetryPolicy
+	if policy != nil && policy.retries(kind) && j.Attempts < policy.MaxAttempts {
+		backoff := policy.backoffFor(j.Attempts)
+		log.Printf("Job %s failed (%s, attempt %d/%d): %v; retrying in %s", j.Name, kind, j.Attempts, policy.MaxAttempts, cause, backoff)
+
+		if kind == FailureKindExecution {
+			// The failed Kubernetes Job still exists (execution failure
+			// doesn't delete it); a retry resubmits the same manifest, so
+			// without this it hits AlreadyExists on every attempt and
+			// burns straight through to the dead-letter queue.
+			if err := s.kubeClient.DeleteJob(context.Background(), j.K8sNamespace, j.K8sName, metav1.DeletePropagationBackground); err != nil {
+				log.Printf("Failed to delete job %s before retry: %v", j.Name, err)
+			}
+		}
+
+		j.Dispatched = false
+		j.K8sName = ""
+		j.K8sNamespace = ""
+		s.priorityQueue.Defer(j, time.Now().Add(backoff))
+		return
+	}
+
+	log.Printf("Job %s exhausted retries after %s failure: %v; moving to dead-letter queue", j.Name, kind, cause)
+	s.moveToDeadLetter(j)
+}
+
+// moveToDeadLetter marks j dead-lettered in the store (excluded from
+// queue replay like a completed job, but not compaction-eligible: it
+// stays around for GET/POST /jobs/dead-letter) and files it in the
+// in-memory dead-letter map for that handler to serve.
+func (s *Server) moveToDeadLetter(j *queue.Job) {
+	s.priorityQueue.MarkDeadLetter(j)
+
+	s.deadLetterMutex.Lock()
+	s.deadLetterJobs[j.ID] = j
+	s.deadLetterMutex.Unlock()
+}
+
+const retryLoopInterval = 1 * time.Second
+
+// retryLoop periodically releases deferred jobs whose backoff has
+// elapsed back onto the main heap, so the queue processor/workers pick
+// them up like any other pending job.
+func (s *Server) retryLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(retryLoopInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.shutdownCh:
+			return
+		case <-ticker.C:
+			if released := s.priorityQueue.ReleaseReady(); released > 0 {
+				log.Printf("Released %d job(s) from retry backoff", released)
+			}
+		}
+	}
+}
+
+// handleDeadLetterJobs serves GET /jobs/dead-letter, listing every job
+// that exhausted its RetryPolicy.
+func (s *Server) handleDeadLetterJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.deadLetterMutex.RLock()
+	response := make([]DeadLetterJobResponse, 0, len(s.deadLetterJobs))
+	for _, job := range s.deadLetterJobs {
+		response = append(response, DeadLetterJobResponse{
+			ID:        job.ID,
+			Name:      job.Name,
+			Priority:  job.Priority,
+			Attempts:  job.Attempts,
+			LastError: job.LastError,
+		})
+	}
+	s.deadLetterMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleDeadLetterRequeue serves POST /jobs/dead-letter/{id}/requeue,
+// resetting a dead-lettered job's retry state and pushing it back onto
+// the queue at its original priority.
+func (s *Server) handleDeadLetterRequeue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/jobs/dead-letter/")
+	id, suffix, found := strings.Cut(path, "/")
+	if !found || suffix != "requeue" || id == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.deadLetterMutex.Lock()
+	job, ok := s.deadLetterJobs[id]
+	if ok {
+		delete(s.deadLetterJobs, id)
+	}
+	s.deadLetterMutex.Unlock()
+
+	if !ok {
+		http.Error(w, "Job not found in dead-letter queue", http.StatusNotFound)
+		return
+	}
+
+	job.Attempts = 0
+	job.LastError = ""
+	job.Dispatched = false
+	job.DeadLetter = false
+	job.K8sName = ""
+	job.K8sNamespace = ""
+	job.EnqueueTime = time.Now()
+	s.priorityQueue.PushJob(job)
+
+	log.Printf("Requeued dead-letter job %s", job.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(JobResponse{
+		ID:       job.ID,
+		Name:     job.Name,
+		Priority: job.Priority,
+	})
+}
+
+// maybePreempt evicts the lowest-priority preemptible running job when
+// candidate's effective priority clears it by more than
+// s.preemptionMargin and concurrency is saturated. The evicted job is
+// deleted from Kubernetes and re-enqueued at its original priority so it
+// competes for the next available slot like any other pending job.
+func (s *Server) maybePreempt(candidate *queue.Job) {
+	if s.runningCount() < s.maxConcurrency {
+		return
+	}
 
-			log.Printf("Removed job %s from tracking map", j.Name)
+	victim := s.lowestPriorityPreemptibleRunningJob()
+	if victim == nil {
+		return
+	}
+
+	candidateEff := s.priorityQueue.EffectivePriority(candidate)
+	if candidateEff-victim.Priority <= s.preemptionMargin {
+		return
+	}
+
+	log.Printf("Preempting job %s (priority %d) in favor of job %s (effective priority %d)",
+		victim.Name, victim.Priority, candidate.Name, candidateEff)
+
+	s.watchCancelMutex.Lock()
+	if cancel, ok := s.watchCancels[victim.ID]; ok {
+		cancel()
+	}
+	s.watchCancelMutex.Unlock()
+
+	if err := s.kubeClient.DeleteJob(context.Background(), victim.K8sNamespace, victim.K8sName, metav1.DeletePropagationBackground); err != nil {
+		log.Printf("Failed to preempt job %s: %v", victim.Name, err)
+		return
+	}
+
+	// The canceled watch above will also fall out of watchDispatchedJob
+	// and call removeRunningJob(victim) on its way out; that's fine,
+	// removeRunningJob only releases the quota bucket the first time.
+	s.removeRunningJob(victim)
+
+	victim.Dispatched = false
+	victim.K8sName = ""
+	victim.K8sNamespace = ""
+	s.priorityQueue.PushJob(victim)
+}
+
+// lowestPriorityPreemptibleRunningJob returns the running job with the
+// lowest Priority that opted into preemption via JobSpec.Preemptible, or
+// nil if none of the currently running jobs are preemptible.
+func (s *Server) lowestPriorityPreemptibleRunningJob() *queue.Job {
+	s.runningJobsMutex.RLock()
+	defer s.runningJobsMutex.RUnlock()
+
+	var victim *queue.Job
+	for _, job := range s.runningJobs {
+		if !job.Preemptible {
+			continue
+		}
+		if victim == nil || job.Priority < victim.Priority {
+			victim = job
+		}
+	}
+	return victim
+}
+
+// reattachDispatchedJobs recovers jobs that were marked dispatched in the
+// store before a crash or restart, and resumes watching them in
+// Kubernetes rather than resubmitting duplicates.
+func (s *Server) reattachDispatchedJobs() error {
+	dispatched, err := s.store.LoadDispatched()
+	if err != nil {
+		return err
+	}
+
+	for _, j := range dispatched {
+		log.Printf("Reattaching to dispatched job %s (k8s job %s/%s) after restart", j.Name, j.K8sNamespace, j.K8sName)
+
+		s.runningJobsMutex.Lock()
+		s.runningJobs[j.ID] = j
+		s.runningJobsMutex.Unlock()
+
+		go s.watchDispatchedJob(j, j.K8sName, j.K8sNamespace)
+	}
+
+	return nil
+}
+
+// reattachDeadLetterJobs reloads jobs the store recorded as
+// dead-lettered before a restart back into the in-memory dead-letter
+// map, so GET/POST /jobs/dead-letter keeps seeing them across restarts.
+func (s *Server) reattachDeadLetterJobs() error {
+	deadLettered, err := s.store.LoadDeadLetter()
+	if err != nil {
+		return err
+	}
+
+	s.deadLetterMutex.Lock()
+	defer s.deadLetterMutex.Unlock()
+	for _, j := range deadLettered {
+		log.Printf("Reattaching dead-lettered job %s after restart", j.Name)
+		s.deadLetterJobs[j.ID] = j
+	}
+
+	return nil
+}
+
+// nextDispatchableJob drains the whole queue, evaluating every pending
+// job's current effective priority to find the highest one whose quota
+// bucket has room, reserving that bucket's slot in the process. Every
+// job it doesn't choose is pushed back onto the heap so it's
+// reconsidered on the next tick. Returns nil if no job is currently
+// dispatchable, either because the queue is empty or every remaining
+// job's bucket is saturated.
+//
+// It can't stop early once it finds a candidate: effective priority ages
+// with time (see MaxPriorityQueue's doc comment), so successive PopJobs
+// are not guaranteed to come back in non-increasing effective-priority
+// order, and a higher-effective job can be sitting deeper in the queue
+// than one already considered.
+//
+// Among jobs tied on effective priority, the one from the bucket with
+// less weight-normalized usage (scheduler.QuotaRule.Weight) is preferred,
+// so a heavier-weighted bucket gets a bigger share of contested slots
+// without ever jumping ahead of a genuinely higher-priority job.
+func (s *Server) nextDispatchableJob() *queue.Job {
+	if s.scheduler == nil {
+		return s.priorityQueue.PopJob()
+	}
+
+	var skipped []*queue.Job
+	var chosen *queue.Job
+	var chosenBucket string
+	var chosenEff int
+
+	for s.priorityQueue.Len() > 0 {
+		job := s.priorityQueue.PopJob()
+		if job == nil {
 			break
 		}
+
+		eff := s.priorityQueue.EffectivePriority(job)
+
+		jobSpec, _ := job.Spec.(JobSpec)
+		bucketKey, ok := s.scheduler.TryAcquire(jobSpec.Namespace, jobSpec.Labels)
+		if !ok {
+			skipped = append(skipped, job)
+			continue
+		}
+
+		if chosen == nil || eff > chosenEff || (eff == chosenEff && s.scheduler.FairerThan(bucketKey, chosenBucket)) {
+			if chosen != nil {
+				s.scheduler.Release(chosenBucket)
+				chosen.SchedulerBucket = ""
+				skipped = append(skipped, chosen)
+			}
+			job.SchedulerBucket = bucketKey
+			chosen = job
+			chosenBucket = bucketKey
+			chosenEff = eff
+		} else {
+			s.scheduler.Release(bucketKey)
+			skipped = append(skipped, job)
+		}
+	}
+
+	for _, job := range skipped {
+		s.priorityQueue.PushJob(job)
+	}
+
+	return chosen
+}
+
+func (s *Server) handleSchedulerState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.scheduler == nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"enabled": false,
+			"buckets": []scheduler.BucketState{},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":          true,
+		"buckets":          s.scheduler.State(),
+		"quotaBlockedJobs": s.quotaBlockedJobs(),
+	})
+}
+
+// quotaBlockedJobs counts pending jobs whose quota bucket is currently
+// saturated, as a point-in-time gauge for operators tuning MaxConcurrent.
+// Unlike counting TryAcquire failures, this doesn't inflate with how
+// often a blocked job happens to get polled.
+func (s *Server) quotaBlockedJobs() int {
+	blocked := 0
+	for _, job := range s.priorityQueue.PendingJobs() {
+		jobSpec, _ := job.Spec.(JobSpec)
+		if s.scheduler.Blocked(jobSpec.Namespace, jobSpec.Labels) {
+			blocked++
+		}
+	}
+	return blocked
+}
+
+// handleConfigQuotas hot-reloads the quota rules enforced by
+// nextDispatchableJob from a POSTed JSON array of scheduler.QuotaRule.
+func (s *Server) handleConfigQuotas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.scheduler == nil {
+		http.Error(w, "Quota scheduling is not enabled on this server", http.StatusNotImplemented)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	rules, err := scheduler.UnmarshalRules(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.scheduler.SetRules(rules)
+	log.Printf("Reloaded %d quota rule(s)", len(rules))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.scheduler.State())
+}
+
+// templatePutRequest is the body of PUT /templates/{name}.
+type templatePutRequest struct {
+	Skeleton    string                `json:"skeleton"`
+	ParamSchema []templates.ParamSpec `json:"paramSchema"`
+}
+
+// templatePreviewRequest is the body of GET /templates/{name}/preview.
+type templatePreviewRequest struct {
+	Params map[string]interface{} `json:"params"`
+}
+
+// handleTemplates serves PUT /templates/{name} (register a new, immutable
+// revision of a job template) and GET /templates/{name}/preview (render a
+// template against params without submitting it).
+func (s *Server) handleTemplates(w http.ResponseWriter, r *http.Request) {
+	if s.templates == nil {
+		http.Error(w, "Template-based submission is not enabled on this server", http.StatusNotImplemented)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/templates/")
+	name, suffix, hasSuffix := strings.Cut(path, "/")
+	if name == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if hasSuffix {
+		if suffix != "preview" {
+			http.NotFound(w, r)
+			return
+		}
+		s.handleTemplatePreview(w, r, name)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		s.handleTemplatePut(w, r, name)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleTemplatePut(w http.ResponseWriter, r *http.Request, name string) {
+	var req templatePutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Error decoding request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := s.templates.Put(name, req.Skeleton, req.ParamSchema)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Registered template %s revision %d", tmpl.Name, tmpl.Version)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tmpl)
+}
+
+func (s *Server) handleTemplatePreview(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req templatePreviewRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Error decoding request: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	_, rendered, err := s.templates.Render(name, req.Params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(rendered)
+}
+
+// removeRunningJob stops tracking j as running and releases its quota
+// bucket. It's called from more than one path for the same job (e.g. a
+// preempted job is removed both by maybePreempt and, moments later, by
+// its canceled watch falling out of watchDispatchedJob), so the bucket
+// release is guarded to fire only once per dispatch: SchedulerBucket is
+// cleared under runningJobsMutex the first time through, and a second
+// call sees it already empty and releases nothing.
+func (s *Server) removeRunningJob(j *queue.Job) {
+	s.runningJobsMutex.Lock()
+	delete(s.runningJobs, j.ID)
+	bucketKey := j.SchedulerBucket
+	j.SchedulerBucket = ""
+	s.runningJobsMutex.Unlock()
+
+	if s.scheduler != nil && bucketKey != "" {
+		s.scheduler.Release(bucketKey)
+	}
+
+	log.Printf("Removed job %s from tracking map", j.ID)
+}
+
+// setJobStatus records the latest known phase for a job and broadcasts
+// it to any subscribers of /jobs/events.
+func (s *Server) setJobStatus(j *queue.Job, namespace, phase string) {
+	status := JobStatus{
+		ID:        j.ID,
+		Name:      j.Name,
+		Namespace: namespace,
+		Priority:  j.Priority,
+		Phase:     phase,
+		UpdatedAt: time.Now(),
+	}
+
+	s.statusMutex.Lock()
+	s.jobStatuses[j.ID] = status
+	s.statusMutex.Unlock()
+
+	s.broadcastStatus(status)
+}
+
+func (s *Server) broadcastStatus(status JobStatus) {
+	event := jobStatusEvent{Type: "status", Status: status}
+
+	s.subscribersMutex.Lock()
+	defer s.subscribersMutex.Unlock()
+	for ch := range s.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Dropping status event for subscriber, channel full")
+		}
+	}
+}
+
+func (s *Server) subscribe() chan jobStatusEvent {
+	ch := make(chan jobStatusEvent, 16)
+	s.subscribersMutex.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.subscribersMutex.Unlock()
+	return ch
+}
+
+func (s *Server) unsubscribe(ch chan jobStatusEvent) {
+	s.subscribersMutex.Lock()
+	delete(s.subscribers, ch)
+	s.subscribersMutex.Unlock()
+	close(ch)
+}
+
+// handleJobStatus serves GET /jobs/{id}/status with the last-known
+// lifecycle phase for a submitted job.
+func (s *Server) handleJobStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	id, suffix, found := strings.Cut(path, "/")
+	if !found || suffix != "status" || id == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.statusMutex.RLock()
+	status, ok := s.jobStatuses[id]
+	s.statusMutex.RUnlock()
+
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleJobEvents serves GET /jobs/events as a Server-Sent Events stream,
+// pushing a JSON-encoded jobStatusEvent each time a tracked job's phase
+// changes.
+func (s *Server) handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch := s.subscribe()
+	defer s.unsubscribe(ch)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.shutdownCh:
+			return
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			payload, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Error marshaling job event: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", payload)
+			flusher.Flush()
+		}
 	}
 }
@@ -0,0 +1,252 @@
+// Package templates lets operators register parameterized batch/v1.Job
+// skeletons once and have callers submit jobs by name and params instead
+// of hand-crafting a full Kubernetes Job manifest on every request.
+package templates
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"text/template"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// ParamSpec describes one parameter a template accepts.
+type ParamSpec struct {
+	Name     string      `json:"name"`
+	Type     ParamType   `json:"type"`
+	Required bool        `json:"required"`
+	Enum     []string    `json:"enum,omitempty"`
+	Default  interface{} `json:"default,omitempty"`
+}
+
+// ParamType constrains the JSON type a param value must decode to.
+type ParamType string
+
+const (
+	ParamTypeString ParamType = "string"
+	ParamTypeNumber ParamType = "number"
+	ParamTypeBool   ParamType = "bool"
+)
+
+// Template is one immutable revision of a named job skeleton. Put never
+// mutates an existing revision; it appends a new one, so a
+// previously-rendered job's provenance (name+version) stays reproducible.
+type Template struct {
+	Name        string      `json:"name"`
+	Version     int         `json:"version"`
+	Skeleton    string      `json:"-"`
+	ParamSchema []ParamSpec `json:"paramSchema"`
+	CreatedAt   time.Time   `json:"createdAt"`
+}
+
+// Registry stores every revision of every named template, keyed by name.
+type Registry struct {
+	mu        sync.RWMutex
+	revisions map[string][]*Template
+}
+
+// NewRegistry returns an empty template registry.
+func NewRegistry() *Registry {
+	return &Registry{revisions: make(map[string][]*Template)}
+}
+
+// Put validates skeleton as a text/template and appends it as a new,
+// immutable revision of name. The previous revision (if any) is left
+// untouched, so jobs already rendered from it remain reproducible.
+func (r *Registry) Put(name, skeleton string, schema []ParamSpec) (*Template, error) {
+	if _, err := template.New(name).Funcs(templateFuncs).Parse(skeleton); err != nil {
+		return nil, fmt.Errorf("invalid template %s: %v", name, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing := r.revisions[name]
+	tmpl := &Template{
+		Name:        name,
+		Version:     len(existing) + 1,
+		Skeleton:    skeleton,
+		ParamSchema: schema,
+		CreatedAt:   time.Now(),
+	}
+	r.revisions[name] = append(existing, tmpl)
+	return tmpl, nil
+}
+
+// Latest returns the most recent revision of name.
+func (r *Registry) Latest(name string) (*Template, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	revisions := r.revisions[name]
+	if len(revisions) == 0 {
+		return nil, false
+	}
+	return revisions[len(revisions)-1], true
+}
+
+// Version returns a specific revision of name, 1-indexed.
+func (r *Registry) Version(name string, version int) (*Template, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	revisions := r.revisions[name]
+	if version < 1 || version > len(revisions) {
+		return nil, false
+	}
+	return revisions[version-1], true
+}
+
+// escapedParam wraps a string param value for template data: String()
+// returns the JSON-escaped form so a skeleton's default "{{.param}}"
+// substitution inside a JSON string literal stays valid JSON, while
+// toJson unwraps back to the original, unescaped string so it isn't
+// escaped twice.
+type escapedParam struct {
+	raw     string
+	escaped string
+}
+
+func (e escapedParam) String() string { return e.escaped }
+
+// templateFuncs are available to a skeleton in addition to the default
+// text/template builtins. toJson lets a skeleton embed a param as a raw
+// JSON value (e.g. {{.tags | toJson}} for a []string param) instead of a
+// bare string substitution.
+var templateFuncs = template.FuncMap{
+	"toJson": func(v interface{}) (string, error) {
+		if p, ok := v.(escapedParam); ok {
+			v = p.raw
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	},
+}
+
+// Render validates params against name's latest revision's schema, fills
+// in defaults for anything omitted, executes the skeleton, and decodes
+// the result into a batch/v1.Job. It also returns the raw rendered JSON,
+// which GET /templates/{name}/preview returns to the caller unsubmitted.
+func (r *Registry) Render(name string, params map[string]interface{}) (*batchv1.Job, []byte, error) {
+	tmpl, ok := r.Latest(name)
+	if !ok {
+		return nil, nil, fmt.Errorf("template %s not found", name)
+	}
+
+	resolved, err := resolveParams(tmpl.ParamSchema, params)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid params for template %s: %v", name, err)
+	}
+
+	parsed, err := template.New(tmpl.Name).Funcs(templateFuncs).Parse(tmpl.Skeleton)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid template %s revision %d: %v", name, tmpl.Version, err)
+	}
+
+	var rendered bytes.Buffer
+	if err := parsed.Execute(&rendered, wrapEscapedParams(resolved)); err != nil {
+		return nil, nil, fmt.Errorf("failed to render template %s: %v", name, err)
+	}
+
+	var job batchv1.Job
+	if err := json.Unmarshal(rendered.Bytes(), &job); err != nil {
+		return nil, nil, fmt.Errorf("template %s did not render to a valid batch/v1.Job: %v", name, err)
+	}
+
+	return &job, rendered.Bytes(), nil
+}
+
+// wrapEscapedParams returns a copy of resolved with every string value
+// wrapped in an escapedParam, so a skeleton's default "{{.param}}"
+// substitution inside a JSON string literal stays valid JSON even when
+// the param value itself contains special characters, while {{.param |
+// toJson}} still sees (and marshals) the original, unescaped string
+// instead of escaping it a second time. Non-string values (numbers,
+// bools) are passed through unchanged.
+func wrapEscapedParams(resolved map[string]interface{}) map[string]interface{} {
+	wrapped := make(map[string]interface{}, len(resolved))
+	for k, v := range resolved {
+		s, ok := v.(string)
+		if !ok {
+			wrapped[k] = v
+			continue
+		}
+		quoted, err := json.Marshal(s)
+		if err != nil {
+			wrapped[k] = v
+			continue
+		}
+		// Strip the surrounding quotes json.Marshal added: the skeleton
+		// supplies its own quotes around "{{.param}}".
+		wrapped[k] = escapedParam{raw: s, escaped: string(quoted[1 : len(quoted)-1])}
+	}
+	return wrapped
+}
+
+// resolveParams checks required/typed/enum constraints and returns a new
+// map with defaults filled in for anything the caller omitted.
+func resolveParams(schema []ParamSpec, params map[string]interface{}) (map[string]interface{}, error) {
+	resolved := make(map[string]interface{}, len(schema))
+	for k, v := range params {
+		resolved[k] = v
+	}
+
+	for _, spec := range schema {
+		v, present := resolved[spec.Name]
+		if !present {
+			if spec.Required {
+				return nil, fmt.Errorf("missing required param %q", spec.Name)
+			}
+			if spec.Default != nil {
+				resolved[spec.Name] = spec.Default
+			}
+			continue
+		}
+
+		if err := checkType(spec, v); err != nil {
+			return nil, fmt.Errorf("param %q: %v", spec.Name, err)
+		}
+	}
+
+	return resolved, nil
+}
+
+func checkType(spec ParamSpec, v interface{}) error {
+	switch spec.Type {
+	case ParamTypeString, "":
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("expected a string")
+		}
+		if len(spec.Enum) > 0 && !contains(spec.Enum, s) {
+			return fmt.Errorf("must be one of %v", spec.Enum)
+		}
+	case ParamTypeNumber:
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("expected a number")
+		}
+	case ParamTypeBool:
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("expected a bool")
+		}
+	default:
+		return fmt.Errorf("unknown param type %q", spec.Type)
+	}
+	return nil
+}
+
+func contains(values []string, v string) bool {
+	for _, candidate := range values {
+		if candidate == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package templates
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const jobSkeleton = `{
+	"metadata": {"name": "{{.name}}"},
+	"spec": {
+		"template": {
+			"spec": {
+				"containers": [{"name": "worker", "image": "worker:latest", "args": ["--gpu={{.gpu}}"]}],
+				"restartPolicy": "Never"
+			}
+		}
+	}
+}`
+
+func TestRender_EscapesSpecialCharactersInStringParams(t *testing.T) {
+	r := NewRegistry()
+	if _, err := r.Put("gpu-job", jobSkeleton, []ParamSpec{
+		{Name: "name", Type: ParamTypeString, Required: true},
+		{Name: "gpu", Type: ParamTypeString, Required: true},
+	}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	job, rendered, err := r.Render("gpu-job", map[string]interface{}{
+		"name": "job-1",
+		"gpu":  `a100" && rm -rf /`,
+	})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if job == nil {
+		t.Fatalf("Render returned a nil job")
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(rendered, &decoded); err != nil {
+		t.Fatalf("rendered output is not valid JSON: %v\n%s", err, rendered)
+	}
+}
+
+const toJsonSkeleton = `{
+	"metadata": {"name": "{{.name}}", "annotations": {"gpu": {{.gpu | toJson}}}},
+	"spec": {
+		"template": {
+			"spec": {
+				"containers": [{"name": "worker", "image": "worker:latest"}],
+				"restartPolicy": "Never"
+			}
+		}
+	}
+}`
+
+// TestRender_ToJsonIsNotDoubleEscaped verifies a skeleton can register
+// (Put) and render (Render) a param piped through toJson, and that the
+// value reaches the output escaped exactly once, not twice.
+func TestRender_ToJsonIsNotDoubleEscaped(t *testing.T) {
+	r := NewRegistry()
+	if _, err := r.Put("gpu-job-tojson", toJsonSkeleton, []ParamSpec{
+		{Name: "name", Type: ParamTypeString, Required: true},
+		{Name: "gpu", Type: ParamTypeString, Required: true},
+	}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	want := `a100" && rm -rf /`
+	job, rendered, err := r.Render("gpu-job-tojson", map[string]interface{}{
+		"name": "job-3",
+		"gpu":  want,
+	})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if job == nil {
+		t.Fatalf("Render returned a nil job")
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(rendered, &decoded); err != nil {
+		t.Fatalf("rendered output is not valid JSON: %v\n%s", err, rendered)
+	}
+	annotations := decoded["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+	if got := annotations["gpu"]; got != want {
+		t.Errorf("annotations[gpu] = %q, want %q (toJson must not double-escape)", got, want)
+	}
+}
+
+func TestRender_PlainParamsUnaffected(t *testing.T) {
+	r := NewRegistry()
+	if _, err := r.Put("gpu-job", jobSkeleton, []ParamSpec{
+		{Name: "name", Type: ParamTypeString, Required: true},
+		{Name: "gpu", Type: ParamTypeString, Required: true},
+	}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	job, _, err := r.Render("gpu-job", map[string]interface{}{
+		"name": "job-2",
+		"gpu":  "a100",
+	})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if job.ObjectMeta.Name != "job-2" {
+		t.Errorf("job.Name = %q, want %q", job.ObjectMeta.Name, "job-2")
+	}
+}